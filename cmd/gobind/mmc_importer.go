@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/build"
@@ -9,8 +10,13 @@ import (
 	"go/token"
 	"go/types"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
 )
 
 // mmcImporter is an importer that implements the `go/importer.Importer`
@@ -33,79 +39,401 @@ import (
 type mmcImporter struct {
 	Ctx         build.Context
 	stdImporter types.Importer
+
+	// Targets, when non-empty, is the set of platforms being bound (e.g.
+	// android/arm64, ios/arm64). A package is loaded per target so that
+	// platform-suffixed files (_android.go, _ios.go, ...) and cgo are
+	// accounted for. If empty, Ctx alone is used, preserving single-target
+	// behavior.
+	Targets []build.Context
+
+	// modules caches go.mod lookups by module root directory so that a
+	// package with many /vN imports doesn't reparse the same go.mod
+	// repeatedly.
+	modules *moduleCache
+}
+
+// targets returns the build contexts to load the package under: Targets if
+// set, otherwise just Ctx.
+func (imp *mmcImporter) targets() []build.Context {
+	if len(imp.Targets) > 0 {
+		return imp.Targets
+	}
+	return []build.Context{imp.Ctx}
 }
 
 // Import tries to import the given package path and returns it's type information.
 // `path` is _not_ an absolute file path but rather a package path used in an
 // `import` statement.
+//
+// When more than one target platform is configured, path's file set is
+// loaded and type-checked separately per target rather than as one merged
+// set: a package that implements the same exported symbol differently per
+// platform (e.g. foo_android.go and foo_ios.go both declaring `type Foo
+// struct{...}`) is the normal gomobile pattern, and feeding both into a
+// single types.Config.Check would fail with a redeclaration error. The
+// first target's *types.Package is returned as the bound API surface, and
+// checkTargetParity compares every target's exported symbols so that one
+// missing from some targets is reported as an error instead of silently
+// dropped.
 func (imp *mmcImporter) Import(path string) (*types.Package, error) {
 
 	if imp.stdImporter == nil {
 		imp.stdImporter = importer.ForCompiler(token.NewFileSet(), "source", nil)
 	}
 
+	if imp.modules == nil {
+		imp.modules = newModuleCache()
+	}
+
 	// try the std importer first (double import but easy to implement)
 	if pkg, err := imp.stdImporter.Import(path); err == nil {
 		return pkg, err
 	}
 
-	pkgSrcPath := filepath.Join(imp.Ctx.GOPATH, "src", path)
+	targets := imp.targets()
 
-	fileSrcs, err := loadPackageSources(imp.Ctx, path, pkgSrcPath)
+	perTarget, err := loadPackageSourcesForTargets(targets, imp.modules, path)
 	if err != nil {
 		return nil, err
 	}
 
+	pkgs := make([]*types.Package, len(targets))
+	for i, srcs := range perTarget {
+		pkg, err := imp.checkSources(path, targets[i], srcs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: type-checking target %s/%s: %w", path, targets[i].GOOS, targets[i].GOARCH, err)
+		}
+		pkgs[i] = pkg
+	}
+
+	if len(targets) > 1 {
+		if err := checkTargetParity(path, targets, pkgs); err != nil {
+			return nil, err
+		}
+	}
+
+	return pkgs[0], nil
+}
+
+// checkSources parses fileSrcs, rewrites any /vN imports the on-disk go.mod
+// layout confirms are safe to strip, and type-checks the result as path.
+// ctx is the build context fileSrcs was loaded under, which may differ from
+// imp.Ctx when checking a non-primary bind target, and is what the /vN
+// rewrite decision must be made against.
+func (imp *mmcImporter) checkSources(path string, ctx build.Context, fileSrcs map[string][]byte) (*types.Package, error) {
 	fset := token.NewFileSet()
 	var astFiles []*ast.File
 	for fpath, src := range fileSrcs {
+		// assembly files are loaded alongside the Go sources but are not Go
+		// and have nothing for the type checker.
+		if filepath.Ext(fpath) != ".go" {
+			continue
+		}
+
 		astf, err := parser.ParseFile(fset, fpath, string(src), parser.ParseComments)
 		if err != nil {
 			return nil, err
 		}
 
-		// inspect the parsed files and replace any path imports with major versions
-		// as non-versioned import paths.
-		for _, imp := range astf.Imports {
+		astFiles = append(astFiles, astf)
+	}
+
+	// Only rewrite /vN imports for files that live inside a tree that has
+	// opted into modules; pure GOPATH packages are left untouched, matching
+	// upstream's behavior.
+	imp.rewriteMajorVersionImports(astFiles, ctx, filepath.Join(ctx.GOPATH, "src", path))
+
+	conf := types.Config{
+		Importer: imp,
+		// cgo files import "C", which no importer can resolve.
+		FakeImportC: true,
+	}
+
+	return conf.Check(path, fset, astFiles, nil)
+}
+
+// checkTargetParity compares the exported symbols of path's already
+// type-checked per-target packages, returning an error naming any symbol
+// that isn't available under every target.
+func checkTargetParity(path string, targets []build.Context, pkgs []*types.Package) error {
+	bySymbol := make(map[string][]build.Context) // symbol -> targets missing it
+
+	allSymbols := make(map[string]bool)
+	exported := make([]map[string]bool, len(pkgs))
+
+	for i, pkg := range pkgs {
+		names := make(map[string]bool)
+		scope := pkg.Scope()
+		for _, n := range scope.Names() {
+			if scope.Lookup(n).Exported() {
+				names[n] = true
+				allSymbols[n] = true
+			}
+		}
+		exported[i] = names
+	}
+
+	for symbol := range allSymbols {
+		for i, names := range exported {
+			if !names[symbol] {
+				bySymbol[symbol] = append(bySymbol[symbol], targets[i])
+			}
+		}
+	}
+
+	if len(bySymbol) == 0 {
+		return nil
+	}
+
+	symbols := make([]string, 0, len(bySymbol))
+	for s := range bySymbol {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "%s: symbol(s) not available on all bind targets:", path)
+	for _, s := range symbols {
+		fmt.Fprintf(&msg, "\n  %s: missing for", s)
+		for _, ctx := range bySymbol[s] {
+			fmt.Fprintf(&msg, " %s/%s", ctx.GOOS, ctx.GOARCH)
+		}
+	}
+
+	return errors.New(msg.String())
+}
+
+// rewriteMajorVersionImports inspects the parsed files and rewrites any
+// import containing a `/vN` path element to drop that element, but only when
+// it can verify on disk that doing so is safe: the importing file must live
+// under a tree with a go.mod, and the *imported* path's own module root must
+// declare itself, via its go.mod `module` directive, as ending in that exact
+// `/vN`. This mirrors the "minimum module compatibility" algorithm used by
+// the `go` command itself, rather than blindly stripping every `/vN` it
+// sees (a path like ".../thing/v2/sub" may be a legitimate v2 subdirectory
+// layout rather than a module major-version suffix).
+func (imp *mmcImporter) rewriteMajorVersionImports(astFiles []*ast.File, ctx build.Context, srcDir string) {
+	gopathSrc := filepath.Join(ctx.GOPATH, "src")
+
+	if _, _, ok, err := imp.modules.findModuleRoot(srcDir, gopathSrc); err != nil || !ok {
+		return
+	}
+
+	for _, astf := range astFiles {
+		for _, spec := range astf.Imports {
+			vN, ok := vNSuffix(spec.Path.Value)
+			if !ok {
+				continue
+			}
+
+			importDir := filepath.Join(gopathSrc, strings.Trim(spec.Path.Value, `"`))
+			if fi, err := os.Stat(importDir); err == nil && fi.IsDir() {
+				// the import resolves as written (e.g. a v2/ subdirectory
+				// layout), so its /vN is not a version suffix to strip.
+				continue
+			}
+
+			_, mf, ok, err := imp.modules.findModuleRoot(importDir, gopathSrc)
+			if err != nil || !ok || mf.Module == nil {
+				continue
+			}
+
+			if !strings.HasSuffix(mf.Module.Mod.Path, "/"+vN) {
+				continue
+			}
+
 			// TODO(tristian): Use the second return value to compute the new
-			// position for the imp.Path token if possible.
-			r, _ := removeMajorVersionFromPath(imp.Path.Value)
+			// position for the spec.Path token if possible.
+			r, _ := removeMajorVersionFromPath(spec.Path.Value)
 
 			// unsure if replacing the value will affect the `Pos` and `End`
 			// properties of the import or how it will manifest
 			// latter in the AST processing / checking.
-			imp.Path.Value = r
+			spec.Path.Value = r
 		}
-
-		astFiles = append(astFiles, astf)
 	}
+}
 
-	conf := types.Config{
-		Importer: imp,
-	}
+// moduleCache caches parsed go.mod files by the directory that contains
+// them, so that resolving many imports against the same module tree only
+// reads and parses each go.mod once.
+type moduleCache struct {
+	mu  sync.Mutex
+	mod map[string]*modfile.File // module root dir -> parsed go.mod, nil if none found there
+}
 
-	return conf.Check(path, fset, astFiles, nil)
+func newModuleCache() *moduleCache {
+	return &moduleCache{mod: make(map[string]*modfile.File)}
+}
+
+// findModuleRoot walks up from dir, stopping once it reaches gopathSrc,
+// looking for the nearest go.mod. It returns the directory the go.mod was
+// found in and its parsed contents, or ok == false if none was found.
+func (c *moduleCache) findModuleRoot(dir, gopathSrc string) (root string, mf *modfile.File, ok bool, err error) {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		c.mu.Lock()
+		cached, hit := c.mod[dir]
+		c.mu.Unlock()
+
+		if hit {
+			if cached != nil {
+				return dir, cached, true, nil
+			}
+		} else if data, rerr := ioutil.ReadFile(filepath.Join(dir, "go.mod")); rerr == nil {
+			parsed, perr := modfile.Parse(filepath.Join(dir, "go.mod"), data, nil)
+			if perr != nil {
+				return "", nil, false, perr
+			}
+
+			c.mu.Lock()
+			c.mod[dir] = parsed
+			c.mu.Unlock()
+
+			return dir, parsed, true, nil
+		} else {
+			c.mu.Lock()
+			c.mod[dir] = nil
+			c.mu.Unlock()
+		}
+
+		if dir == gopathSrc || dir == filepath.Dir(dir) {
+			return "", nil, false, nil
+		}
+	}
 }
 
 // loadPackageSources reads each of the Go file's sources for the given package
 // under a specific build context. It respects the use of build tags to determine
-// which files should be loaded.
-func loadPackageSources(ctx build.Context, packagePath, srcDir string) (map[string][]byte, error) {
+// which files should be loaded, including cgo and assembly sources relevant
+// to that context: a GOOS/GOARCH pair that enables cgo gets CgoFiles, and
+// SFiles are always included since they carry no build-tag ambiguity of
+// their own.
+//
+// The on-disk directory read for packagePath is not always srcDir's sibling
+// under GOPATH/src: resolveSourceDir prefers a vendor/ directory found in an
+// ancestor of srcDir, then a `replace` directive in the nearest go.mod,
+// before falling back to the plain GOPATH layout.
+func loadPackageSources(ctx build.Context, mods *moduleCache, packagePath, srcDir string) (map[string][]byte, error) {
 
-	pkgCnts, err := ctx.Import(packagePath, srcDir, 0)
+	dir, err := resolveSourceDir(ctx, mods, srcDir, packagePath)
 	if err != nil {
 		return nil, err
 	}
 
+	pkgCnts, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	names := append([]string{}, pkgCnts.GoFiles...)
+	names = append(names, pkgCnts.SFiles...)
+	if ctx.CgoEnabled {
+		names = append(names, pkgCnts.CgoFiles...)
+	}
+
 	// produce absolute paths
-	filePaths := make([]string, len(pkgCnts.GoFiles))
-	for i := range pkgCnts.GoFiles {
-		filePaths[i] = filepath.Join(srcDir, pkgCnts.GoFiles[i])
+	filePaths := make([]string, len(names))
+	for i := range names {
+		filePaths[i] = filepath.Join(dir, names[i])
 	}
 
 	return loadFileContents(filePaths...)
 }
 
+// loadPackageSourcesForTargets loads packagePath's sources under each of the
+// given build contexts, one file set per target, so each can be type-checked
+// on its own — a package with platform-suffixed files (foo_android.go,
+// foo_ios.go, ...) may declare the same exported symbol differently per
+// platform, so its targets' file sets can't simply be merged and checked
+// together.
+func loadPackageSourcesForTargets(targets []build.Context, mods *moduleCache, packagePath string) ([]map[string][]byte, error) {
+	perTarget := make([]map[string][]byte, len(targets))
+
+	for i, ctx := range targets {
+		srcDir := filepath.Join(ctx.GOPATH, "src", packagePath)
+
+		srcs, err := loadPackageSources(ctx, mods, packagePath, srcDir)
+		if err != nil {
+			return nil, fmt.Errorf("loading %q for %s/%s: %w", packagePath, ctx.GOOS, ctx.GOARCH, err)
+		}
+
+		perTarget[i] = srcs
+	}
+
+	return perTarget, nil
+}
+
+// resolveSourceDir determines the on-disk directory that should be read for
+// packagePath, as imported from a file in srcDir. It checks, in order:
+//
+//  1. a vendor/<packagePath> directory in srcDir or one of its ancestors;
+//  2. a `replace` directive in the nearest go.mod to srcDir, in either its
+//     path-form (a filesystem directory) or version-form (a module cache
+//     entry);
+//  3. the plain GOPATH/src/<packagePath> layout.
+func resolveSourceDir(ctx build.Context, mods *moduleCache, srcDir, packagePath string) (string, error) {
+	gopathSrc := filepath.Join(ctx.GOPATH, "src")
+
+	if dir, ok := findVendorDir(srcDir, gopathSrc, packagePath); ok {
+		return dir, nil
+	}
+
+	if root, mf, ok, err := mods.findModuleRoot(srcDir, gopathSrc); err != nil {
+		return "", err
+	} else if ok {
+		if dir, ok := resolveReplace(ctx, root, mf, packagePath); ok {
+			return dir, nil
+		}
+	}
+
+	return filepath.Join(gopathSrc, packagePath), nil
+}
+
+// findVendorDir walks up from srcDir looking for a vendor/<packagePath>
+// directory, the way the go command resolves vendored imports.
+func findVendorDir(srcDir, gopathSrc, packagePath string) (string, bool) {
+	for dir := filepath.Clean(srcDir); ; dir = filepath.Dir(dir) {
+		candidate := filepath.Join(dir, "vendor", packagePath)
+		if fi, err := os.Stat(candidate); err == nil && fi.IsDir() {
+			return candidate, true
+		}
+
+		if dir == gopathSrc || dir == filepath.Dir(dir) {
+			return "", false
+		}
+	}
+}
+
+// resolveReplace looks for a `replace` directive in mf, rooted at
+// moduleRoot, whose old path is packagePath or an ancestor of it, and
+// returns the directory it resolves to. Both replace forms are supported:
+// path-form (`replace old => ../local/path`), resolved relative to
+// moduleRoot, and version-form (`replace old => new vX.Y.Z`), resolved into
+// the module cache under GOPATH/pkg/mod.
+func resolveReplace(ctx build.Context, moduleRoot string, mf *modfile.File, packagePath string) (string, bool) {
+	for _, r := range mf.Replace {
+		old := r.Old.Path
+		if packagePath != old && !strings.HasPrefix(packagePath, old+"/") {
+			continue
+		}
+
+		rest := strings.TrimPrefix(strings.TrimPrefix(packagePath, old), "/")
+
+		if r.New.Version == "" {
+			dir := r.New.Path
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(moduleRoot, dir)
+			}
+			return filepath.Join(dir, rest), true
+		}
+
+		return filepath.Join(ctx.GOPATH, "pkg", "mod", fmt.Sprintf("%s@%s", r.New.Path, r.New.Version), rest), true
+	}
+
+	return "", false
+}
+
 // removeMajorVersionFromPath removes the `/vN` parts of a package path.
 //
 //	* "example.com/org/library/v6/pkg" -> "example.com/org/library/pkg"
@@ -136,6 +464,18 @@ func removeMajorVersionFromPath(pkgPath string) (string, int) {
 	return r, len(pkgPath) - len(r)
 }
 
+// vNSuffix reports whether pkgPath contains a `vN` path element and, if so,
+// returns that element on its own (e.g. "v2"), stripped of the surrounding
+// quotes an import literal carries.
+func vNSuffix(pkgPath string) (string, bool) {
+	for _, p := range strings.Split(strings.Trim(pkgPath, `"`), "/") {
+		if isVNPart(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
 // isVNPart determines whether a string is the major version path component.
 // It assumes that the string does not contain `"/"`, i.e it expects it to
 // match `vN` or `vN"`
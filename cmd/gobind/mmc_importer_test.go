@@ -2,6 +2,9 @@ package main
 
 import (
 	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -80,6 +83,279 @@ func Test_removeMajorVersionFromPath(t *testing.T) {
 	}
 }
 
+func Test_vNSuffix(t *testing.T) {
+	tests := []struct {
+		In     string
+		Want   string
+		WantOK bool
+	}{
+		{"example.com/org/thing/v2/sub", "v2", true},
+		{`"example.com/org/thing/v2/sub"`, "v2", true},
+		{"example.com/org/thing/sub", "", false},
+		{`"example.com/org/library"`, "", false},
+	}
+
+	for i, tst := range tests {
+		r, ok := vNSuffix(tst.In)
+		if r != tst.Want || ok != tst.WantOK {
+			t.Errorf("failed on %d: wanted (%q, %v), got (%q, %v)", i, tst.Want, tst.WantOK, r, ok)
+		}
+	}
+}
+
+func Test_moduleCache_findModuleRoot(t *testing.T) {
+	gopathSrc, err := ioutil.TempDir("", "mmc-gopath-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopathSrc)
+
+	modRoot := filepath.Join(gopathSrc, "example.com", "org", "thing", "v2")
+	if err := os.MkdirAll(modRoot, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	const gomod = "module example.com/org/thing/v2\n\ngo 1.14\n"
+	if err := ioutil.WriteFile(filepath.Join(modRoot, "go.mod"), []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := filepath.Join(modRoot, "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newModuleCache()
+
+	root, mf, ok, err := c.findModuleRoot(sub, gopathSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected to find a go.mod walking up from", sub)
+	}
+	if root != modRoot {
+		t.Errorf("wanted root %q, got %q", modRoot, root)
+	}
+	if mf.Module.Mod.Path != "example.com/org/thing/v2" {
+		t.Errorf("wanted module path %q, got %q", "example.com/org/thing/v2", mf.Module.Mod.Path)
+	}
+
+	// a tree with no go.mod at all should report ok == false rather than
+	// erroring.
+	plain := filepath.Join(gopathSrc, "example.com", "org", "plain")
+	if err := os.MkdirAll(plain, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, ok, err := c.findModuleRoot(plain, gopathSrc); err != nil || ok {
+		t.Errorf("expected ok == false, err == nil for a GOPATH-only tree, got ok=%v err=%v", ok, err)
+	}
+}
+
+func Test_resolveSourceDir_vendor(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "mmc-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	srcDir := filepath.Join(gopath, "src", "importer")
+	vendored := filepath.Join(srcDir, "vendor", "example.com", "foo")
+	if err := os.MkdirAll(vendored, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := build.Default
+	ctx.GOPATH = gopath
+
+	dir, err := resolveSourceDir(ctx, newModuleCache(), srcDir, "example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != vendored {
+		t.Errorf("wanted %q, got %q", vendored, dir)
+	}
+}
+
+func Test_resolveSourceDir_replacePath(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "mmc-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	srcDir := filepath.Join(gopath, "src", "importer")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	local := filepath.Join(gopath, "src", "localfoo")
+	if err := os.MkdirAll(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	const gomod = "module importer\n\ngo 1.14\n\nreplace example.com/foo => ../localfoo\n"
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "go.mod"), []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := build.Default
+	ctx.GOPATH = gopath
+
+	dir, err := resolveSourceDir(ctx, newModuleCache(), srcDir, "example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != local {
+		t.Errorf("wanted %q, got %q", local, dir)
+	}
+}
+
+func Test_resolveSourceDir_replaceVersion(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "mmc-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	srcDir := filepath.Join(gopath, "src", "importer")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	const gomod = "module importer\n\ngo 1.14\n\nreplace example.com/bar => example.com/barfork v1.2.3\n"
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "go.mod"), []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := build.Default
+	ctx.GOPATH = gopath
+
+	dir, err := resolveSourceDir(ctx, newModuleCache(), srcDir, "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(gopath, "pkg", "mod", "example.com/barfork@v1.2.3")
+	if dir != want {
+		t.Errorf("wanted %q, got %q", want, dir)
+	}
+}
+
+func Test_mmcImporter_targets(t *testing.T) {
+	android := build.Default
+	android.GOOS = "android"
+	ios := build.Default
+	ios.GOOS = "darwin"
+
+	imp := &mmcImporter{Ctx: build.Default, Targets: []build.Context{android, ios}}
+	if got := imp.targets(); len(got) != 2 {
+		t.Fatalf("wanted 2 targets, got %d", len(got))
+	}
+
+	single := &mmcImporter{Ctx: build.Default}
+	if got := single.targets(); len(got) != 1 || got[0].GOOS != build.Default.GOOS || got[0].GOARCH != build.Default.GOARCH {
+		t.Fatalf("wanted targets() to fall back to Ctx, got %v", got)
+	}
+}
+
+// writeTargetPackage writes a package under gopath/src/importPath with one
+// file per suffix/source pair (e.g. "_android.go" -> android-only source),
+// the way gomobile bindings keep platform-specific implementations of the
+// same exported symbol in separate files.
+func writeTargetPackage(t *testing.T, gopath, importPath string, files map[string]string) string {
+	t.Helper()
+
+	dir := filepath.Join(gopath, "src", importPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for suffix, src := range files {
+		name := "pkg" + suffix + ".go"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func Test_mmcImporter_Import_perPlatformSymbol(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "mmc-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	const importPath = "example.com/multitarget"
+
+	writeTargetPackage(t, gopath, importPath, map[string]string{
+		"_android": "package multitarget\n\ntype Foo struct {\n\tAndroid string\n}\n\nfunc NewFoo() *Foo {\n\treturn &Foo{Android: \"android\"}\n}\n",
+		"_windows": "package multitarget\n\ntype Foo struct {\n\tWindows string\n}\n\nfunc NewFoo() *Foo {\n\treturn &Foo{Windows: \"windows\"}\n}\n",
+	})
+
+	android := build.Default
+	android.GOOS, android.GOARCH = "android", "arm64"
+	android.GOPATH = gopath
+
+	// windows is deliberately paired with android instead of linux: go/build
+	// treats GOOS=android as also matching "_linux" suffixed files (Android
+	// is Linux-based), so a linux target would make pkg_linux.go visible to
+	// the android build context too and defeat the point of this test.
+	windows := build.Default
+	windows.GOOS, windows.GOARCH = "windows", "amd64"
+	windows.GOPATH = gopath
+
+	imp := &mmcImporter{Ctx: android, Targets: []build.Context{android, windows}}
+
+	// Union-checking pkg_android.go and pkg_windows.go together would fail
+	// here with "Foo redeclared in this block" since both declare the same
+	// exported symbol differently; checking each target on its own must
+	// succeed instead.
+	pkg, err := imp.Import(importPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkg.Scope().Lookup("NewFoo") == nil {
+		t.Error("expected NewFoo to be visible in the imported package")
+	}
+}
+
+func Test_mmcImporter_Import_targetParityMismatch(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "mmc-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	const importPath = "example.com/partialtarget"
+
+	writeTargetPackage(t, gopath, importPath, map[string]string{
+		"_android": "package partialtarget\n\nfunc AndroidOnly() {}\n",
+		"_windows": "package partialtarget\n\nfunc WindowsOnly() {}\n",
+	})
+
+	android := build.Default
+	android.GOOS, android.GOARCH = "android", "arm64"
+	android.GOPATH = gopath
+
+	// windows, not linux: GOOS=android also matches "_linux" suffixed files,
+	// which would make the android target pull in pkg_linux.go too and
+	// muddy what this test is isolating.
+	windows := build.Default
+	windows.GOOS, windows.GOARCH = "windows", "amd64"
+	windows.GOPATH = gopath
+
+	imp := &mmcImporter{Ctx: android, Targets: []build.Context{android, windows}}
+
+	if _, err := imp.Import(importPath); err == nil {
+		t.Fatal("expected an error reporting the symbols missing from some targets")
+	}
+}
+
 func Test_isVNPart(t *testing.T) {
 	tests := []struct {
 		In   string
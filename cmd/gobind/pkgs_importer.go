@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// pkgsImporter is a types.Importer backed by golang.org/x/tools/go/packages.
+// Unlike mmcImporter, which reimplements type-checking on top of go/build
+// and hand-rolled AST rewriting, pkgsImporter understands go modules: it
+// honors `replace` and `exclude` directives, resolves dependencies out of
+// the module cache, and follows vendor/ directories, because packages.Load
+// shells out to the `go` tool itself to do that work.
+//
+// It only does this inside a tree that has opted into modules. In a true
+// GOPATH tree with no go.mod, `go/packages` has nothing to honor and
+// `GO111MODULE=on` would just make module-unaware code fail to resolve, so
+// pkgsImporter falls back to mmcImporter there.
+type pkgsImporter struct {
+	Ctx build.Context
+	Dir string // directory imports are resolved relative to
+
+	modules *moduleCache
+
+	fallback *mmcImporter
+
+	// infos records the *types.Info produced for each package path loaded
+	// through go/packages, so that callers that need position or comment
+	// data alongside the *types.Package don't have to re-load the package
+	// themselves.
+	infos map[string]*types.Info
+}
+
+// newPkgsImporter returns a pkgsImporter that resolves imports relative to
+// dir, falling back to mmcImporter for packages in a true GOPATH tree.
+func newPkgsImporter(ctx build.Context, dir string) *pkgsImporter {
+	return &pkgsImporter{
+		Ctx:      ctx,
+		Dir:      dir,
+		modules:  newModuleCache(),
+		fallback: &mmcImporter{Ctx: ctx},
+		infos:    make(map[string]*types.Info),
+	}
+}
+
+// Import resolves path using golang.org/x/tools/go/packages when Dir is
+// inside a module tree, and mmcImporter otherwise.
+func (imp *pkgsImporter) Import(path string) (*types.Package, error) {
+	gopathSrc := filepath.Join(imp.Ctx.GOPATH, "src")
+	if _, _, ok, err := imp.modules.findModuleRoot(imp.Dir, gopathSrc); err != nil || !ok {
+		return imp.fallback.Import(path)
+	}
+
+	cfg := &packages.Config{
+		Mode: packages.LoadAllSyntax,
+		Dir:  imp.Dir,
+		Env:  append(os.Environ(), "GO111MODULE=on"),
+	}
+
+	pkgs, err := packages.Load(cfg, path)
+	if err != nil {
+		return nil, fmt.Errorf("pkgsImporter: loading %q: %w", path, err)
+	}
+	if err := packageLoadErrors(pkgs); err != nil {
+		return nil, fmt.Errorf("pkgsImporter: %q failed to load cleanly: %w", path, err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("pkgsImporter: expected exactly one package for %q, got %d", path, len(pkgs))
+	}
+
+	pkg := pkgs[0]
+	imp.infos[path] = pkg.TypesInfo
+
+	return pkg.Types, nil
+}
+
+// packageLoadErrors collects every packages.Error found across pkgs (and
+// their dependencies) into a single error, or nil if there were none. Unlike
+// packages.PrintErrors, this doesn't write to os.Stderr as a side effect —
+// the caller already returns the failure to its own caller as an error.
+func packageLoadErrors(pkgs []*packages.Package) error {
+	var errs []string
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, e := range pkg.Errors {
+			errs = append(errs, e.Error())
+		}
+	})
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "\n"))
+}
+
+// Info returns the *types.Info recorded the last time path was imported
+// through go/packages, so that downstream binders keep access to position
+// and comment data. It returns nil for packages resolved via the
+// mmcImporter fallback, which doesn't populate type info this way.
+func (imp *pkgsImporter) Info(path string) *types.Info {
+	return imp.infos[path]
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func Test_pkgsImporter(t *testing.T) {
+	// this test is meant to be a manual debugging aid, comment
+	// this out when debugging.
+	t.Skip()
+
+	imp := newPkgsImporter(build.Default, ".")
+
+	const packagePath = "github.com/minio/minio-go/v7"
+
+	pkg, err := imp.Import(packagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Package: ", pkg)
+}
+
+func Test_packageLoadErrors(t *testing.T) {
+	if err := packageLoadErrors(nil); err != nil {
+		t.Errorf("wanted nil for no packages, got %v", err)
+	}
+
+	clean := &packages.Package{ID: "example.com/clean"}
+	if err := packageLoadErrors([]*packages.Package{clean}); err != nil {
+		t.Errorf("wanted nil for a package with no errors, got %v", err)
+	}
+
+	leaf := &packages.Package{
+		ID:     "example.com/leaf",
+		Errors: []packages.Error{{Msg: "leaf error", Kind: packages.TypeError}},
+	}
+	mid := &packages.Package{
+		ID:      "example.com/mid",
+		Imports: map[string]*packages.Package{"example.com/leaf": leaf},
+	}
+	root := &packages.Package{
+		ID:      "example.com/root",
+		Errors:  []packages.Error{{Msg: "root error", Kind: packages.ListError}},
+		Imports: map[string]*packages.Package{"example.com/mid": mid},
+	}
+
+	err := packageLoadErrors([]*packages.Package{root})
+	if err == nil {
+		t.Fatal("wanted an error describing both the root and leaf failures")
+	}
+	if !strings.Contains(err.Error(), "root error") || !strings.Contains(err.Error(), "leaf error") {
+		t.Errorf("wanted error to mention both nested failures, got %q", err.Error())
+	}
+}
+
+func Test_pkgsImporter_Import_fallback(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "pkgs-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	dir := filepath.Join(gopath, "src", "importer")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := build.Default
+	ctx.GOPATH = gopath
+
+	const packagePath = "example.com/doesnotexist"
+
+	imp := newPkgsImporter(ctx, dir)
+
+	_, gotErr := imp.Import(packagePath)
+	_, wantErr := imp.fallback.Import(packagePath)
+
+	if gotErr == nil || wantErr == nil {
+		t.Fatalf("expected both calls to fail for a nonexistent package, got gotErr=%v wantErr=%v", gotErr, wantErr)
+	}
+	if gotErr.Error() != wantErr.Error() {
+		t.Errorf("expected Import to delegate to mmcImporter when dir has no go.mod:\ngot:  %v\nwant: %v", gotErr, wantErr)
+	}
+}